@@ -0,0 +1,109 @@
+package main
+
+import "testing"
+
+func TestParseDirective_Valid(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want func(t *testing.T, tfn testFn)
+	}{
+		{
+			"tags",
+			"@tags: kv, query, slow",
+			func(t *testing.T, tfn testFn) {
+				if len(tfn.Tags) != 3 || tfn.Tags[0] != "kv" || tfn.Tags[1] != "query" || tfn.Tags[2] != "slow" {
+					t.Errorf("Tags = %v", tfn.Tags)
+				}
+			},
+		},
+		{
+			"requires",
+			"@requires: server>=7.2",
+			func(t *testing.T, tfn testFn) {
+				if tfn.RequiredVersion != "server>=7.2" {
+					t.Errorf("RequiredVersion = %q", tfn.RequiredVersion)
+				}
+			},
+		},
+		{
+			"timeout",
+			"@timeout: 30s",
+			func(t *testing.T, tfn testFn) {
+				if tfn.Timeout != "30s" {
+					t.Errorf("Timeout = %q", tfn.Timeout)
+				}
+			},
+		},
+		{
+			"skip_on",
+			"@skip_on: windows, macos",
+			func(t *testing.T, tfn testFn) {
+				if len(tfn.SkipOn) != 2 || tfn.SkipOn[0] != "windows" || tfn.SkipOn[1] != "macos" {
+					t.Errorf("SkipOn = %v", tfn.SkipOn)
+				}
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var tfn testFn
+			var violations []violation
+			parseDirective(&tfn, docLine{text: c.text, file: "kv.rs", line: 1, col: 5}, &violations)
+			if len(violations) != 0 {
+				t.Fatalf("unexpected violations: %v", violations)
+			}
+			c.want(t, tfn)
+		})
+	}
+}
+
+func TestParseDirective_Invalid(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+	}{
+		{"bad tags", "@tags: kv,,query"},
+		{"bad requires", "@requires: not-a-version"},
+		{"bad timeout", "@timeout: soon"},
+		{"bad skip_on", "@skip_on: !windows"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var tfn testFn
+			var violations []violation
+			parseDirective(&tfn, docLine{text: c.text, file: "kv.rs", line: 3, col: 5}, &violations)
+			if len(violations) != 1 {
+				t.Fatalf("got %d violations, want 1: %v", len(violations), violations)
+			}
+			if violations[0].line != 3 || violations[0].col != 5 {
+				t.Errorf("violation location = %d:%d, want 3:5", violations[0].line, violations[0].col)
+			}
+		})
+	}
+}
+
+func TestParseDirective_MissingColon(t *testing.T) {
+	var tfn testFn
+	var violations []violation
+	parseDirective(&tfn, docLine{text: "@tags kv,query", file: "kv.rs", line: 7, col: 5}, &violations)
+	if len(violations) != 1 {
+		t.Fatalf("got %d violations, want 1: %v", len(violations), violations)
+	}
+	v := violations[0]
+	if v.file != "kv.rs" || v.line != 7 || v.col != 5 {
+		t.Errorf("violation location = %s:%d:%d, want kv.rs:7:5", v.file, v.line, v.col)
+	}
+	if len(tfn.Tags) != 0 {
+		t.Errorf("Tags should be untouched on a syntax error, got %v", tfn.Tags)
+	}
+}
+
+func TestParseDirective_UnknownAtTag(t *testing.T) {
+	var tfn testFn
+	var violations []violation
+	parseDirective(&tfn, docLine{text: "@flaky: true", file: "kv.rs", line: 1, col: 5}, &violations)
+	if len(violations) != 0 {
+		t.Fatalf("unrecognized @-words outside the directive set should be left alone, got %v", violations)
+	}
+}
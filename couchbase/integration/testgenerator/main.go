@@ -2,64 +2,523 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
+// testFn describes a single discovered `pub async fn test_*` and everything
+// the generated harness needs to know about it.
+type testFn struct {
+	File          string   // base name of the .rs file, without extension
+	Name          string   // the bare test_* function name
+	ModPath       []string // enclosing `mod` blocks, outermost first
+	Ignored       bool     // #[ignore]
+	CfgGates      []string // raw predicate strings from #[cfg(...)]
+	ShouldPanic   bool     // #[should_panic]
+	ExpectedPanic string   // expected = "..." from #[should_panic], empty if none given
+
+	Tags            []string // @tags: kv,query,slow
+	RequiredVersion string   // @requires: server>=7.2, empty if unset
+	Timeout         string   // @timeout: 30s, raw duration text, empty if unset
+	SkipOn          []string // @skip_on: windows,macos
+}
+
+// violation is a single directive syntax error, reported with enough
+// location detail for a reader to jump straight to it.
+type violation struct {
+	file string
+	line int
+	col  int
+	msg  string
+}
+
+func (v violation) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", v.file, v.line, v.col, v.msg)
+}
+
+// qualifiedName is the path used to call the test function from the
+// generated `tests()` body, e.g. `kv::get::test_get_basic`.
+func (t testFn) qualifiedName() string {
+	parts := append([]string{t.File}, t.ModPath...)
+	parts = append(parts, t.Name)
+	return strings.Join(parts, "::")
+}
+
+var (
+	fnStartRe      = regexp.MustCompile(`^pub\s+async\s+fn\s+(test_[A-Za-z0-9_]*)\s*\(`)
+	modStartRe     = regexp.MustCompile(`^(?:pub(?:\([^)]*\))?\s+)?mod\s+([A-Za-z0-9_]+)\s*\{`)
+	attrRe         = regexp.MustCompile(`^#!?\[\s*([A-Za-z0-9_:]+)\s*(?:\((.*)\))?\s*\]$`)
+	docRe          = regexp.MustCompile(`^///\s*(.*)$`)
+	directiveRe    = regexp.MustCompile(`^@(tags|requires|timeout|skip_on):\s*(.*)$`)
+	directiveKeyRe = regexp.MustCompile(`^@(tags|requires|timeout|skip_on)\b`)
+
+	tagListRe  = regexp.MustCompile(`^[A-Za-z0-9_]+(\s*,\s*[A-Za-z0-9_]+)*$`)
+	requiresRe = regexp.MustCompile(`^[A-Za-z_]+\s*(>=|<=|==|>|<)\s*\d+(\.\d+)*$`)
+	timeoutRe  = regexp.MustCompile(`^\d+(ms|s|m|h)$`)
+
+	// rawStringOpenRe matches the opening delimiter of a Rust raw string
+	// literal (r"...", r#"..."#, r##"..."##, ...) anchored to the scan
+	// position; its capture is the hash count, which must match exactly on
+	// the closing delimiter.
+	rawStringOpenRe = regexp.MustCompile(`^r(#*)"`)
+)
+
+// literalState carries stripLiterals' position across calls, the same way
+// stripBlockComments carries inBlockComment, since either kind of string
+// literal may span multiple lines.
+type literalState struct {
+	inString    bool
+	inRawString bool
+	rawHashes   int
+}
+
+// stripLiterals blanks out the contents of both plain "..." string literals
+// and raw r#"..."# string literals (keeping their delimiters) in a single
+// pass, so brace counting below isn't thrown off by stray `{`/`}` characters
+// inside either kind of literal. This has to be one pass rather than two: a
+// plain string can contain a `"` preceded by `r` and hashes (e.g. `"see
+// r#"`), which a raw-string scan run independently of plain-string tracking
+// would misidentify as a raw string opening, eating real code up to the next
+// coincidental closing delimiter.
+func stripLiterals(line string, state literalState) (code string, next literalState) {
+	var sb strings.Builder
+	escaped := false
+	i := 0
+	for i < len(line) {
+		switch {
+		case state.inRawString:
+			closer := `"` + strings.Repeat("#", state.rawHashes)
+			idx := strings.Index(line[i:], closer)
+			if idx == -1 {
+				return sb.String(), state
+			}
+			sb.WriteString(closer)
+			i += idx + len(closer)
+			state.inRawString = false
+		case state.inString:
+			c := line[i]
+			switch {
+			case escaped:
+				escaped = false
+				sb.WriteByte(c)
+			case c == '\\':
+				escaped = true
+				sb.WriteByte(c)
+			case c == '"':
+				state.inString = false
+				sb.WriteByte(c)
+			}
+			i++
+		default:
+			if m := rawStringOpenRe.FindStringSubmatchIndex(line[i:]); m != nil {
+				sb.WriteString(line[i : i+m[1]])
+				state.rawHashes = m[3] - m[2]
+				state.inRawString = true
+				i += m[1]
+				continue
+			}
+			if line[i] == '"' {
+				state.inString = true
+			}
+			sb.WriteByte(line[i])
+			i++
+		}
+	}
+	return sb.String(), state
+}
+
+// stripBlockComments removes /* ... */ regions from line, which may start
+// or end the line already inside a block comment carried over from a
+// previous line via inBlockComment. Without this, a block comment sitting
+// between a `mod` block and a test (or containing a stray `{`/`}`) would
+// be scanned as code, desyncing brace depth and mod-path tracking the same
+// way unstripped string literals used to.
+func stripBlockComments(line string, inBlockComment bool) (code string, stillIn bool) {
+	var sb strings.Builder
+	i := 0
+	for i < len(line) {
+		if inBlockComment {
+			end := strings.Index(line[i:], "*/")
+			if end == -1 {
+				return sb.String(), true
+			}
+			i += end + 2
+			inBlockComment = false
+			continue
+		}
+		if strings.HasPrefix(line[i:], "/*") {
+			inBlockComment = true
+			i += 2
+			continue
+		}
+		sb.WriteByte(line[i])
+		i++
+	}
+	return sb.String(), inBlockComment
+}
+
+// docLine is a `///` comment line preceding a test function, kept alongside
+// its source position so directive syntax errors can be reported precisely.
+type docLine struct {
+	text string
+	file string
+	line int
+	col  int
+}
+
+// parseDirective validates a single `@directive: value` doc comment and
+// applies it to t. Syntax errors are appended to violations rather than
+// aborting the scan, so a single run reports every offender in the file.
+func parseDirective(t *testFn, d docLine, violations *[]violation) {
+	fail := func(msg string) {
+		*violations = append(*violations, violation{file: d.file, line: d.line, col: d.col, msg: msg})
+	}
+
+	m := directiveRe.FindStringSubmatch(d.text)
+	if m == nil {
+		if key := directiveKeyRe.FindStringSubmatch(d.text); key != nil {
+			fail(fmt.Sprintf("@%s: expected a \":\" after the directive name, got %q", key[1], d.text))
+		}
+		return
+	}
+	directive, value := m[1], strings.TrimSpace(m[2])
+
+	switch directive {
+	case "tags":
+		if !tagListRe.MatchString(value) {
+			fail(fmt.Sprintf("@tags: expected a comma-separated list of identifiers, got %q", value))
+			return
+		}
+		for _, tag := range strings.Split(value, ",") {
+			t.Tags = append(t.Tags, strings.TrimSpace(tag))
+		}
+	case "requires":
+		if !requiresRe.MatchString(value) {
+			fail(fmt.Sprintf("@requires: expected e.g. \"server>=7.2\", got %q", value))
+			return
+		}
+		t.RequiredVersion = value
+	case "timeout":
+		if !timeoutRe.MatchString(value) {
+			fail(fmt.Sprintf("@timeout: expected a duration like \"30s\" or \"2m\", got %q", value))
+			return
+		}
+		t.Timeout = value
+	case "skip_on":
+		if !tagListRe.MatchString(value) {
+			fail(fmt.Sprintf("@skip_on: expected a comma-separated list of platforms, got %q", value))
+			return
+		}
+		for _, p := range strings.Split(value, ",") {
+			t.SkipOn = append(t.SkipOn, strings.TrimSpace(p))
+		}
+	}
+}
+
+// scanFile walks a single .rs file line by line, tracking `mod` nesting,
+// pending attributes and pending doc comments, and reports every `test_*`
+// function it finds along with any directive syntax errors encountered.
+// This replaces the old flat substring match so that multi-line signatures,
+// nested modules, attributes and doc directives are all accounted for.
+func scanFile(path string) ([]testFn, []violation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	base := strings.TrimSuffix(filepath.Base(path), ".rs")
+
+	var fns []testFn
+	var violations []violation
+	var modStack []string
+	var modDepth []int // brace depth at which the corresponding modStack entry was opened
+	var pendingAttrs []string
+	var pendingDocs []docLine
+	var pendingFn string // signature text accumulated while it spans multiple lines
+	depth := 0
+	lineNo := 0
+	var inBlockComment bool
+	var litState literalState
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNo++
+		raw := scanner.Text()
+		codeOnly, stillIn := stripBlockComments(strings.TrimSpace(raw), inBlockComment)
+		inBlockComment = stillIn
+		line := strings.TrimSpace(codeOnly)
+		var braceLine string
+		braceLine, litState = stripLiterals(line, litState)
+
+		if pendingFn != "" {
+			pendingFn += " " + line
+			if strings.Contains(line, "{") || strings.Contains(line, ";") {
+				if m := fnStartRe.FindStringSubmatch(pendingFn); m != nil {
+					fns = append(fns, buildTestFn(base, modStack, m[1], pendingAttrs, pendingDocs, &violations))
+				}
+				pendingAttrs, pendingDocs = nil, nil
+				pendingFn = ""
+			}
+			depth += strings.Count(braceLine, "{") - strings.Count(braceLine, "}")
+			continue
+		}
+
+		switch {
+		case line == "":
+			// blank lines don't break a run of doc/attribute comments
+		case docRe.MatchString(line):
+			pendingDocs = append(pendingDocs, docLine{
+				text: docRe.FindStringSubmatch(line)[1],
+				file: path,
+				line: lineNo,
+				col:  strings.Index(raw, "///") + 1,
+			})
+		case strings.HasPrefix(line, "//"):
+			// plain comments are not attributes or doc comments; leave pending state alone
+		case attrRe.MatchString(line):
+			pendingAttrs = append(pendingAttrs, line)
+		case modStartRe.MatchString(line):
+			m := modStartRe.FindStringSubmatch(line)
+			modStack = append(modStack, m[1])
+			modDepth = append(modDepth, depth)
+			pendingAttrs, pendingDocs = nil, nil
+		case fnStartRe.MatchString(line):
+			m := fnStartRe.FindStringSubmatch(line)
+			if strings.Contains(line, "{") || strings.Contains(line, ";") {
+				fns = append(fns, buildTestFn(base, modStack, m[1], pendingAttrs, pendingDocs, &violations))
+				pendingAttrs, pendingDocs = nil, nil
+			} else {
+				pendingFn = line
+			}
+		default:
+			if !strings.HasPrefix(line, "#[") {
+				pendingAttrs = nil
+				pendingDocs = nil
+			}
+		}
+
+		depth += strings.Count(braceLine, "{") - strings.Count(braceLine, "}")
+		for len(modDepth) > 0 && depth <= modDepth[len(modDepth)-1] {
+			modStack = modStack[:len(modStack)-1]
+			modDepth = modDepth[:len(modDepth)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return fns, violations, nil
+}
+
+func buildTestFn(file string, modStack []string, name string, attrs []string, docs []docLine, violations *[]violation) testFn {
+	t := testFn{
+		File:    file,
+		Name:    name,
+		ModPath: append([]string{}, modStack...),
+	}
+	for _, a := range attrs {
+		m := attrRe.FindStringSubmatch(a)
+		if m == nil {
+			continue
+		}
+		switch m[1] {
+		case "ignore":
+			t.Ignored = true
+		case "should_panic":
+			t.ShouldPanic = true
+			if strings.Contains(m[2], "expected") {
+				if parts := strings.SplitN(m[2], "=", 2); len(parts) == 2 {
+					t.ExpectedPanic = strings.Trim(strings.TrimSpace(parts[1]), `"`)
+				}
+			}
+		case "cfg":
+			t.CfgGates = append(t.CfgGates, m[2])
+		}
+	}
+	for _, d := range docs {
+		parseDirective(&t, d, violations)
+	}
+	return t
+}
+
+// quoteList renders a Go string slice as the element list of a Rust
+// `vec![...]` of owned Strings, suitable for a Vec<String> field.
+func quoteList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, s := range items {
+		quoted[i] = fmt.Sprintf("%q.to_string()", s)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// optionalString renders a Rust `Option<String>` literal: `Some("value")`
+// when present is true, `None` otherwise.
+func optionalString(present bool, value string) string {
+	if !present {
+		return "None"
+	}
+	return fmt.Sprintf("Some(%q.to_string())", value)
+}
+
+// scratchModule is the slash-separated path used to namespace a test's
+// scratch directory, e.g. "kv/get" for `kv::get::test_get_basic`.
+func (t testFn) scratchModule() string {
+	parts := append([]string{t.File}, t.ModPath...)
+	return strings.Join(parts, "/")
+}
+
+// renderTestEntry emits one `tests.push(...)` statement, wrapped in the
+// test's own #[cfg(...)] predicates so a test built out by a disabled
+// feature is left out of all_tests() instead of generating a dangling
+// call to a function the compiler stripped. The pushed block builds a
+// uniquely-named scratch directory, clones config with it attached, and
+// constructs the TestFn with that scratch dir wired in for leak detection.
+func renderTestEntry(t testFn) string {
+	var cfgAttrs strings.Builder
+	for _, gate := range t.CfgGates {
+		fmt.Fprintf(&cfgAttrs, "#[cfg(%s)]\n        ", gate)
+	}
+
+	return fmt.Sprintf(
+		`%stests.push({
+            let scratch_dir = std::env::temp_dir()
+                .join("couchbase-rs")
+                .join(%q)
+                .join(format!("{}-{}", %q, scratch_suffix()));
+            let mut cfg = (*config).clone();
+            cfg.scratch_dir = Some(scratch_dir.clone());
+            TestFn::new("%s", Box::pin(%s(Arc::new(cfg))))
+                .ignored(%t)
+                .cfg_gates(vec![%s])
+                .expected_panic(%s)
+                .tags(vec![%s])
+                .required_version(%s)
+                .timeout(%s)
+                .skip_on(vec![%s])
+                .scratch(scratch_dir)
+        });`,
+		cfgAttrs.String(), t.scratchModule(), t.Name,
+		t.Name, t.qualifiedName(), t.Ignored, quoteList(t.CfgGates), optionalString(t.ShouldPanic, t.ExpectedPanic),
+		quoteList(t.Tags), optionalString(t.RequiredVersion != "", t.RequiredVersion),
+		optionalString(t.Timeout != "", t.Timeout), quoteList(t.SkipOn))
+}
+
+// durations.json records the last observed wall-clock time for each test,
+// keyed by its fully-qualified name (e.g. "kv::get::test_get_basic"), as a
+// JSON object mapping that name to a number of seconds:
+//
+//	{
+//	  "kv::get::test_get_basic": 1.23
+//	}
+//
+// Entries are optional; a test missing from the file is assumed to cost
+// zero seconds until a later run appends a real measurement.
+const durationsFile = "testdata/durations.json"
+
+// loadDurations reads root/durations.json if present, returning an empty
+// map and writing a fresh skeleton file otherwise.
+func loadDurations(root string) (map[string]float64, error) {
+	path := filepath.Join(root, durationsFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte("{}\n"), 0644); err != nil {
+			return nil, err
+		}
+		return map[string]float64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	durations := map[string]float64{}
+	if err := json.Unmarshal(data, &durations); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return durations, nil
+}
+
+// renderManifestEntry emits one TestDescriptor literal for test_manifest().
+func renderManifestEntry(t testFn, durations map[string]float64) string {
+	name := t.qualifiedName()
+	tags := make([]string, len(t.Tags))
+	for i, tag := range t.Tags {
+		tags[i] = fmt.Sprintf("%q", tag)
+	}
+	return fmt.Sprintf(
+		"TestDescriptor { name: %q, tags: &[%s], estimated_secs: %s }",
+		name, strings.Join(tags, ", "), rustFloatLiteral(durations[name]))
+}
+
+// rustFloatLiteral renders v so it always parses as an f64 literal in Rust,
+// where a bare integer like "0" is rejected for an f64-typed field.
+func rustFloatLiteral(v float64) string {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
 func main() {
 	rootFlag := flag.String("root", "./integration", "path to the root tests directory")
 	flag.Parse()
 
-	var testNames []string
+	var tests []testFn
+	var violations []violation
 	err := filepath.Walk(*rootFlag+"/tests",
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			if info.IsDir() {
+			if info.IsDir() || !strings.HasSuffix(path, ".rs") {
 				return nil
 			}
 
-			f, err := os.Open(path)
+			found, fileViolations, err := scanFile(path)
 			if err != nil {
 				return err
 			}
-			defer f.Close()
-
-			scanner := bufio.NewScanner(f)
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-				if !strings.HasPrefix(line, "pub async fn test_") {
-					continue
-				}
-
-				idx := strings.Index(line, "test_")
-				part := line[idx:]
-				bIdx := strings.Index(part, "(")
-				testNames = append(testNames, fmt.Sprintf("%s::%s", strings.TrimSuffix(info.Name(), ".rs"), part[:bIdx]))
-			}
-
-			if err := scanner.Err(); err != nil {
-				return err
-			}
-
+			tests = append(tests, found...)
+			violations = append(violations, fileViolations...)
 			return nil
 		})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if len(violations) > 0 {
+		fmt.Fprintf(os.Stderr, "testgenerator: %d directive error(s) found:\n", len(violations))
+		for _, v := range violations {
+			fmt.Fprintln(os.Stderr, v.String())
+		}
+		os.Exit(1)
+	}
+
+	durations, err := loadDurations(*rootFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	var testfns []string
-	for _, name := range testNames {
-		idx := strings.Index(name, "::")
-		testfns = append(testfns, fmt.Sprintf("TestFn::new(\"%s\", Box::pin(%s(config.clone())))", name[idx+2:], name))
+	var manifest []string
+	for _, t := range tests {
+		testfns = append(testfns, renderTestEntry(t))
+		manifest = append(manifest, renderManifestEntry(t, durations))
 	}
 
-	err = os.WriteFile(*rootFlag+"/test_functions.rs", []byte(fmt.Sprintf(template, strings.Join(testfns, ",\n"))), 0644)
+	rendered := fmt.Sprintf(template, strings.Join(testfns, "\n        "), strings.Join(manifest, ",\n        "))
+	err = os.WriteFile(*rootFlag+"/test_functions.rs", []byte(rendered), 0644)
 	if err != nil {
 		panic(err)
 	}
@@ -69,13 +528,114 @@ var template = `
 use crate::tests::*;
 use crate::util::TestConfig;
 use futures::Future;
+use std::cell::Cell;
+use std::path::PathBuf;
 use std::pin::Pin;
+use std::sync::atomic::{AtomicBool, Ordering};
 use std::sync::Arc;
+use std::time::{SystemTime, UNIX_EPOCH};
 use crate::TestResult;
 
+static KEEP_ON_FAILURE: AtomicBool = AtomicBool::new(false);
+
+/// Sets whether a test's scratch directory survives its own failure,
+/// driven by the runner's ` + "`--keep-on-failure`" + ` flag.
+pub fn set_keep_on_failure(keep: bool) {
+    KEEP_ON_FAILURE.store(keep, Ordering::Relaxed);
+}
+
+fn scratch_suffix() -> u128 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_nanos())
+        .unwrap_or(0)
+}
+
+/// Owns a test's scratch directory and reports anything left behind in it
+/// once the test drops it. Call mark_failed() before dropping so a failing
+/// test's directory can be kept around for inspection.
+pub struct ScratchGuard {
+    pub path: PathBuf,
+    failed: Cell<bool>,
+}
+
+impl ScratchGuard {
+    pub fn new(path: PathBuf) -> Self {
+        std::fs::create_dir_all(&path).expect("failed to create scratch dir");
+        Self {
+            path,
+            failed: Cell::new(false),
+        }
+    }
+
+    pub fn mark_failed(&self) {
+        self.failed.set(true);
+    }
+}
+
+impl Drop for ScratchGuard {
+    fn drop(&mut self) {
+        let residual: Vec<_> = std::fs::read_dir(&self.path)
+            .map(|entries| entries.filter_map(|e| e.ok()).collect())
+            .unwrap_or_default();
+        if !residual.is_empty() {
+            eprintln!(
+                "scratch dir {} leaked {} entr(y/ies): {:?}",
+                self.path.display(),
+                residual.len(),
+                residual.iter().map(|e| e.file_name()).collect::<Vec<_>>(),
+            );
+        }
+        if self.failed.get() && KEEP_ON_FAILURE.load(Ordering::Relaxed) {
+            return;
+        }
+        let _ = std::fs::remove_dir_all(&self.path);
+    }
+}
+
 // Sad panda noises
-pub fn tests(config: Arc<TestConfig>) -> Vec<TestFn> {
-    vec![
+pub fn all_tests(config: Arc<TestConfig>) -> Vec<TestFn> {
+    let mut tests = Vec::new();
+    %s
+    tests
+}
+
+// fnv1a is a hand-rolled FNV-1a hash so shard assignment is stable across
+// Rust versions and platforms, unlike std's SipHash-based DefaultHasher.
+fn fnv1a(s: &str) -> u64 {
+    const FNV_OFFSET: u64 = 0xcbf29ce484222325;
+    const FNV_PRIME: u64 = 0x100000001b3;
+    let mut hash = FNV_OFFSET;
+    for b in s.as_bytes() {
+        hash ^= *b as u64;
+        hash = hash.wrapping_mul(FNV_PRIME);
+    }
+    hash
+}
+
+/// Returns only the tests assigned to shard_index out of shard_total,
+/// using a stable hash of each test's fully-qualified name so repeated
+/// runs across CI workers produce the same, reproducible partitions.
+pub fn tests(config: Arc<TestConfig>, shard_index: usize, shard_total: usize) -> Vec<TestFn> {
+    assert!(shard_total > 0, "shard_total must be at least 1");
+    assert!(shard_index < shard_total, "shard_index must be less than shard_total");
+    all_tests(config)
+        .into_iter()
+        .filter(|t| (fnv1a(&t.name) as usize) %% shard_total == shard_index)
+        .collect()
+}
+
+/// A single test's static metadata, independent of any TestConfig. Read by
+/// CI schedulers that want to bin-pack shards by estimated_secs instead of
+/// naive modulo splitting; see durations.json's schema in testgenerator.
+pub struct TestDescriptor {
+    pub name: &'static str,
+    pub tags: &'static [&'static str],
+    pub estimated_secs: f64,
+}
+
+pub fn test_manifest() -> &'static [TestDescriptor] {
+    &[
         %s
     ]
 }
@@ -83,6 +643,15 @@ pub fn tests(config: Arc<TestConfig>) -> Vec<TestFn> {
 pub struct TestFn {
     pub name: String,
     pub func: Pin<Box<dyn Future<Output = TestResult<bool>> + Send + 'static>>,
+    pub ignored: bool,
+    pub cfg_gates: Vec<String>,
+    pub expected_panic: Option<String>,
+    pub tags: Vec<String>,
+    pub required_version: Option<String>,
+    pub timeout: Option<String>,
+    pub skip_on: Vec<String>,
+    pub scratch_dir: PathBuf,
+    pub scratch: Box<dyn Fn() -> ScratchGuard + Send + Sync>,
 }
 
 impl TestFn {
@@ -93,7 +662,76 @@ impl TestFn {
         Self {
             name: name.into(),
             func,
+            ignored: false,
+            cfg_gates: Vec::new(),
+            expected_panic: None,
+            tags: Vec::new(),
+            required_version: None,
+            timeout: None,
+            skip_on: Vec::new(),
+            scratch_dir: std::env::temp_dir(),
+            scratch: Box::new(|| ScratchGuard::new(std::env::temp_dir())),
         }
     }
+
+    pub fn ignored(mut self, ignored: bool) -> Self {
+        self.ignored = ignored;
+        self
+    }
+
+    pub fn cfg_gates(mut self, cfg_gates: Vec<String>) -> Self {
+        self.cfg_gates = cfg_gates;
+        self
+    }
+
+    pub fn expected_panic(mut self, expected_panic: Option<String>) -> Self {
+        self.expected_panic = expected_panic;
+        self
+    }
+
+    pub fn tags(mut self, tags: Vec<String>) -> Self {
+        self.tags = tags;
+        self
+    }
+
+    pub fn required_version(mut self, required_version: Option<String>) -> Self {
+        self.required_version = required_version;
+        self
+    }
+
+    pub fn timeout(mut self, timeout: Option<String>) -> Self {
+        self.timeout = timeout;
+        self
+    }
+
+    pub fn skip_on(mut self, skip_on: Vec<String>) -> Self {
+        self.skip_on = skip_on;
+        self
+    }
+
+    pub fn scratch(mut self, path: PathBuf) -> Self {
+        self.scratch_dir = path.clone();
+        self.scratch = Box::new(move || ScratchGuard::new(path.clone()));
+        self
+    }
+}
+
+// TestFilter narrows the set of tests returned by select() without
+// recompiling: run with e.g. ` + "`--tags kv --skip slow`" + `.
+#[derive(Default, Clone)]
+pub struct TestFilter {
+    pub tags: Vec<String>,
+    pub skip_tags: Vec<String>,
+}
+
+/// Returns every test matching filter's tags/skip_tags. config is forwarded
+/// to all_tests() to build each test's future; it is not currently checked
+/// against a test's required_version.
+pub fn select(config: &TestConfig, filter: &TestFilter) -> Vec<TestFn> {
+    all_tests(Arc::new(config.clone()))
+        .into_iter()
+        .filter(|t| filter.tags.is_empty() || t.tags.iter().any(|tag| filter.tags.contains(tag)))
+        .filter(|t| !t.tags.iter().any(|tag| filter.skip_tags.contains(tag)))
+        .collect()
 }
 `
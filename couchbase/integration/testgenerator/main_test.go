@@ -0,0 +1,255 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripLiterals_PlainStrings(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{"no string", `if x { y }`, `if x { y }`},
+		{"brace in string", `let s = "{not a brace}";`, `let s = "";`},
+		{"escaped quote", `let s = "a\"{b}\"c";`, `let s = "\"\"";`},
+		{"multiple strings", `f("{a}", "{b}")`, `f("", "")`},
+		{"looks like a raw string opener but isn't one", `let note = "see r#";`, `let note = "";`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, _ := stripLiterals(c.in, literalState{})
+			if got != c.want {
+				t.Errorf("stripLiterals(%q) = %q, want %q", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestStripLiterals_RawStrings(t *testing.T) {
+	cases := []struct {
+		name        string
+		in          string
+		state       literalState
+		wantCode    string
+		wantStillIn bool
+	}{
+		{"no raw string", `let x = 1;`, literalState{}, `let x = 1;`, false},
+		{"single-hash with brace", `r#"{"k}"#`, literalState{}, `r#""#`, false},
+		{"no hash", `r"{a}"`, literalState{}, `r""`, false},
+		{"double hash lets single-hash content through", `r##"a"#b"##`, literalState{}, `r##""##`, false},
+		{"opens without closing", `let q = r#"{`, literalState{}, `let q = r#"`, true},
+		{"closes a carried-over raw string", `still "k}"#; Ok(())`, literalState{inRawString: true, rawHashes: 1}, `"#; Ok(())`, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, next := stripLiterals(c.in, c.state)
+			if code != c.wantCode || next.inRawString != c.wantStillIn {
+				t.Errorf("stripLiterals(%q, %+v) = (%q, inRawString=%v), want (%q, inRawString=%v)",
+					c.in, c.state, code, next.inRawString, c.wantCode, c.wantStillIn)
+			}
+		})
+	}
+}
+
+func TestStripBlockComments(t *testing.T) {
+	cases := []struct {
+		name        string
+		in          string
+		inComment   bool
+		wantCode    string
+		wantStillIn bool
+	}{
+		{"no comment", `mod kv {`, false, `mod kv {`, false},
+		{"single line comment", `mod /* skip { */ kv {`, false, `mod  kv {`, false},
+		{"opens comment", `mod kv { /* a stray { here`, false, `mod kv { `, true},
+		{"continues and closes", `still inside */ fn test_x() {}`, true, ` fn test_x() {}`, false},
+		{"entirely inside", `no closer on this line`, true, ``, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, stillIn := stripBlockComments(c.in, c.inComment)
+			if code != c.wantCode || stillIn != c.wantStillIn {
+				t.Errorf("stripBlockComments(%q, %v) = (%q, %v), want (%q, %v)",
+					c.in, c.inComment, code, stillIn, c.wantCode, c.wantStillIn)
+			}
+		})
+	}
+}
+
+func writeFixture(t *testing.T, contents string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kv.rs")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScanFile_NestedModAndMultilineSignature(t *testing.T) {
+	path := writeFixture(t, `
+mod get {
+    pub async fn test_basic(
+        config: Arc<TestConfig>,
+    ) -> TestResult<bool> {
+        Ok(true)
+    }
+}
+`)
+	fns, violations, err := scanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	if len(fns) != 1 {
+		t.Fatalf("got %d test fns, want 1", len(fns))
+	}
+	got := fns[0].qualifiedName()
+	want := "kv::get::test_basic"
+	if got != want {
+		t.Errorf("qualifiedName() = %q, want %q", got, want)
+	}
+}
+
+func TestScanFile_BlockCommentDoesNotDesyncModDepth(t *testing.T) {
+	path := writeFixture(t, `
+mod get {
+    /* a comment containing a { brace that must not open a scope */
+    pub async fn test_basic(config: Arc<TestConfig>) -> TestResult<bool> { Ok(true) }
+}
+
+pub async fn test_top_level(config: Arc<TestConfig>) -> TestResult<bool> { Ok(true) }
+`)
+	fns, violations, err := scanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	if len(fns) != 2 {
+		t.Fatalf("got %d test fns, want 2: %+v", len(fns), fns)
+	}
+	if got := fns[0].qualifiedName(); got != "kv::get::test_basic" {
+		t.Errorf("fns[0].qualifiedName() = %q, want kv::get::test_basic", got)
+	}
+	if got := fns[1].qualifiedName(); got != "kv::test_top_level" {
+		t.Errorf("fns[1].qualifiedName() = %q, want kv::test_top_level (block comment leaked mod scope)", got)
+	}
+}
+
+func TestScanFile_MultilineBlockComment(t *testing.T) {
+	path := writeFixture(t, `
+mod get {
+    /*
+     * still a comment, with a stray { brace on its own line
+     */
+    pub async fn test_basic(config: Arc<TestConfig>) -> TestResult<bool> { Ok(true) }
+}
+`)
+	fns, _, err := scanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fns) != 1 || fns[0].qualifiedName() != "kv::get::test_basic" {
+		t.Fatalf("got %+v, want a single kv::get::test_basic", fns)
+	}
+}
+
+func TestScanFile_RawStringDoesNotDesyncModDepth(t *testing.T) {
+	path := writeFixture(t, `
+mod get {
+    pub async fn test_raw_query(config: Arc<TestConfig>) -> TestResult<bool> {
+        let query = r#"{"k}"#;
+        Ok(true)
+    }
+    pub async fn test_sibling_in_same_mod(config: Arc<TestConfig>) -> TestResult<bool> {
+        Ok(true)
+    }
+}
+`)
+	fns, violations, err := scanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	if len(fns) != 2 {
+		t.Fatalf("got %d test fns, want 2: %+v", len(fns), fns)
+	}
+	if got := fns[0].qualifiedName(); got != "kv::get::test_raw_query" {
+		t.Errorf("fns[0].qualifiedName() = %q, want kv::get::test_raw_query", got)
+	}
+	if got := fns[1].qualifiedName(); got != "kv::get::test_sibling_in_same_mod" {
+		t.Errorf("fns[1].qualifiedName() = %q, want kv::get::test_sibling_in_same_mod (raw string brace leaked mod scope)", got)
+	}
+}
+
+func TestScanFile_PlainStringLookingLikeRawStringOpenerDoesNotDesyncModDepth(t *testing.T) {
+	path := writeFixture(t, `
+mod get {
+    pub async fn test_note(config: Arc<TestConfig>) -> TestResult<bool> {
+        let note = "see r#";
+        Ok(true)
+    }
+}
+mod upsert {
+    pub async fn test_other(config: Arc<TestConfig>) -> TestResult<bool> {
+        Ok(true)
+    }
+}
+`)
+	fns, violations, err := scanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	if len(fns) != 2 {
+		t.Fatalf("got %d test fns, want 2: %+v", len(fns), fns)
+	}
+	if got := fns[1].qualifiedName(); got != "kv::upsert::test_other" {
+		t.Errorf("fns[1].qualifiedName() = %q, want kv::upsert::test_other (plain string mistaken for a raw string opener leaked mod scope)", got)
+	}
+}
+
+func TestBuildTestFn_Attributes(t *testing.T) {
+	var violations []violation
+	attrs := []string{
+		`#[ignore]`,
+		`#[should_panic(expected = "boom")]`,
+		`#[cfg(feature = "tls")]`,
+	}
+	tfn := buildTestFn("kv", nil, "test_x", attrs, nil, &violations)
+	if !tfn.Ignored {
+		t.Error("expected Ignored = true")
+	}
+	if !tfn.ShouldPanic {
+		t.Error("expected ShouldPanic = true")
+	}
+	if tfn.ExpectedPanic != "boom" {
+		t.Errorf("ExpectedPanic = %q, want %q", tfn.ExpectedPanic, "boom")
+	}
+	if len(tfn.CfgGates) != 1 || tfn.CfgGates[0] != `feature = "tls"` {
+		t.Errorf("CfgGates = %v, want [feature = \"tls\"]", tfn.CfgGates)
+	}
+}
+
+func TestRenderTestEntry_CfgGating(t *testing.T) {
+	tfn := testFn{
+		File:     "kv",
+		Name:     "test_x",
+		CfgGates: []string{`feature = "tls"`, "unix"},
+	}
+	entry := renderTestEntry(tfn)
+	want := "#[cfg(feature = \"tls\")]\n        #[cfg(unix)]\n        tests.push({"
+	if !strings.HasPrefix(entry, want) {
+		t.Errorf("renderTestEntry() does not open with gated tests.push; got:\n%s", entry)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScratchModule(t *testing.T) {
+	tfn := testFn{File: "kv", ModPath: []string{"get", "nested"}, Name: "test_x"}
+	if got, want := tfn.scratchModule(), "kv/get/nested"; got != want {
+		t.Errorf("scratchModule() = %q, want %q", got, want)
+	}
+}
+
+func TestScratchModule_NoModPath(t *testing.T) {
+	tfn := testFn{File: "kv", Name: "test_x"}
+	if got, want := tfn.scratchModule(), "kv"; got != want {
+		t.Errorf("scratchModule() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderTestEntry_ScratchDirNaming(t *testing.T) {
+	tfn := testFn{File: "kv", ModPath: []string{"get"}, Name: "test_basic"}
+	entry := renderTestEntry(tfn)
+
+	if !strings.Contains(entry, `.join("kv/get")`) {
+		t.Errorf("scratch dir is not namespaced by scratchModule(); got:\n%s", entry)
+	}
+	if !strings.Contains(entry, `format!("{}-{}", "test_basic", scratch_suffix())`) {
+		t.Errorf("scratch dir is not suffixed with the test name and a unique suffix; got:\n%s", entry)
+	}
+	if !strings.Contains(entry, `cfg.scratch_dir = Some(scratch_dir.clone());`) {
+		t.Errorf("cloned config is not given the scratch dir; got:\n%s", entry)
+	}
+	if !strings.Contains(entry, `.scratch(scratch_dir)`) {
+		t.Errorf("TestFn is not wired up with its scratch dir; got:\n%s", entry)
+	}
+}
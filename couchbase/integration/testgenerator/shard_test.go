@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRustFloatLiteral(t *testing.T) {
+	cases := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0.0"},
+		{1, "1.0"},
+		{1.5, "1.5"},
+		{0.125, "0.125"},
+	}
+	for _, c := range cases {
+		if got := rustFloatLiteral(c.in); got != c.want {
+			t.Errorf("rustFloatLiteral(%v) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRenderManifestEntry(t *testing.T) {
+	tfn := testFn{File: "kv", ModPath: []string{"get"}, Name: "test_basic", Tags: []string{"kv", "slow"}}
+	durations := map[string]float64{"kv::get::test_basic": 2}
+	got := renderManifestEntry(tfn, durations)
+	want := `TestDescriptor { name: "kv::get::test_basic", tags: &["kv", "slow"], estimated_secs: 2.0 }`
+	if got != want {
+		t.Errorf("renderManifestEntry() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderManifestEntry_MissingDuration(t *testing.T) {
+	tfn := testFn{File: "kv", Name: "test_basic"}
+	got := renderManifestEntry(tfn, map[string]float64{})
+	if !strings.HasSuffix(got, "estimated_secs: 0.0 }") {
+		t.Errorf("a test missing from durations.json should default to 0.0 seconds, got %q", got)
+	}
+}
+
+func TestLoadDurations_WritesSkeletonWhenAbsent(t *testing.T) {
+	root := t.TempDir()
+	durations, err := loadDurations(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(durations) != 0 {
+		t.Fatalf("expected an empty map, got %v", durations)
+	}
+	data, err := os.ReadFile(filepath.Join(root, durationsFile))
+	if err != nil {
+		t.Fatalf("skeleton file was not written: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != "{}" {
+		t.Errorf("skeleton contents = %q, want {}", data)
+	}
+}
+
+func TestLoadDurations_ReadsExisting(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(durationsFile)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, durationsFile)
+	if err := os.WriteFile(path, []byte(`{"kv::get::test_basic": 1.5}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	durations, err := loadDurations(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if durations["kv::get::test_basic"] != 1.5 {
+		t.Errorf("durations = %v, want kv::get::test_basic: 1.5", durations)
+	}
+}
+
+// TestRenderFullOutput runs the generator's rendering step against a couple
+// of fixture-built testFns and asserts the result is free of fmt.Sprintf
+// corruption artifacts (e.g. a stray unescaped `%` in template consuming the
+// manifest argument out of turn and leaving `%!s(MISSING)` in its place).
+func TestRenderFullOutput(t *testing.T) {
+	tests := []testFn{
+		{File: "kv", ModPath: []string{"get"}, Name: "test_basic", Tags: []string{"kv"}},
+		{File: "kv", Name: "test_other", CfgGates: []string{"unix"}},
+	}
+	durations := map[string]float64{}
+
+	var testfns, manifest []string
+	for _, tfn := range tests {
+		testfns = append(testfns, renderTestEntry(tfn))
+		manifest = append(manifest, renderManifestEntry(tfn, durations))
+	}
+
+	rendered := fmt.Sprintf(template, strings.Join(testfns, "\n        "), strings.Join(manifest, ",\n        "))
+
+	if strings.Contains(rendered, "%!") {
+		t.Fatalf("rendered output contains an fmt.Sprintf corruption artifact:\n%s", rendered)
+	}
+	if !strings.Contains(rendered, "test_basic") || !strings.Contains(rendered, "test_other") {
+		t.Fatalf("rendered output is missing one or both test entries:\n%s", rendered)
+	}
+	if !strings.Contains(rendered, `fnv1a(&t.name) as usize) % shard_total == shard_index`) {
+		t.Fatalf("shard predicate's literal %% did not survive Sprintf as a single %%:\n%s", rendered)
+	}
+}